@@ -0,0 +1,104 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// accessListTracer records every (address, slot) pair touched by an EVM
+// execution so that an EIP-2930 access list can be derived from it. The
+// sender and the direct call target are excluded, matching geth's existing
+// eth_createAccessList behaviour: they are always warm regardless of the
+// access list.
+type accessListTracer struct {
+	excluded map[common.Address]struct{}
+	addrs    map[common.Address]map[common.Hash]struct{}
+	order    []common.Address
+}
+
+func newAccessListTracer(from common.Address, to *common.Address) *accessListTracer {
+	t := &accessListTracer{
+		excluded: map[common.Address]struct{}{from: {}},
+		addrs:    make(map[common.Address]map[common.Hash]struct{}),
+	}
+	if to != nil {
+		t.excluded[*to] = struct{}{}
+	}
+	return t
+}
+
+func (t *accessListTracer) touchAddress(addr common.Address) {
+	if _, ok := t.excluded[addr]; ok {
+		return
+	}
+	if _, ok := t.addrs[addr]; !ok {
+		t.addrs[addr] = make(map[common.Hash]struct{})
+		t.order = append(t.order, addr)
+	}
+}
+
+func (t *accessListTracer) touchSlot(addr common.Address, slot common.Hash) {
+	t.touchAddress(addr)
+	if _, ok := t.excluded[addr]; ok {
+		return
+	}
+	t.addrs[addr][slot] = struct{}{}
+}
+
+// hooks returns the tracing.Hooks that feed this tracer.
+func (t *accessListTracer) hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnOpcode: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			stack := scope.StackData()
+			switch vm.OpCode(op) {
+			case vm.SLOAD, vm.SSTORE:
+				if len(stack) >= 1 {
+					slot := common.Hash(stack[len(stack)-1].Bytes32())
+					t.touchSlot(scope.Address(), slot)
+				}
+			case vm.EXTCODECOPY, vm.EXTCODEHASH, vm.EXTCODESIZE, vm.BALANCE, vm.SELFDESTRUCT:
+				if len(stack) >= 1 {
+					t.touchAddress(common.Address(stack[len(stack)-1].Bytes20()))
+				}
+			case vm.DELEGATECALL, vm.CALL, vm.STATICCALL, vm.CALLCODE:
+				if len(stack) >= 2 {
+					t.touchAddress(common.Address(stack[len(stack)-2].Bytes20()))
+				}
+			}
+		},
+	}
+}
+
+// accessList returns the touched (address, slot) pairs as an EIP-2930
+// access list. Addresses are in first-touched order; the order of the
+// storage keys within an address is not defined.
+func (t *accessListTracer) accessList() types.AccessList {
+	list := make(types.AccessList, 0, len(t.order))
+	for _, addr := range t.order {
+		slots := t.addrs[addr]
+		entry := types.AccessTuple{Address: addr, StorageKeys: make([]common.Hash, 0, len(slots))}
+		for slot := range slots {
+			entry.StorageKeys = append(entry.StorageKeys, slot)
+		}
+		list = append(list, entry)
+	}
+	return list
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// StateOverride describes the pre-execution state overrides that can be
+// applied to a single account before a bundle is simulated. Nil fields are
+// left untouched.
+type StateOverride struct {
+	Balance *big.Int
+	Nonce   *uint64
+	Code    []byte
+	// State replaces the entire storage of the account when non-nil.
+	State map[common.Hash]common.Hash
+	// StateDiff merges individual storage slots into the existing storage.
+	StateDiff map[common.Hash]common.Hash
+}
+
+// StateOverrides maps accounts to the overrides that should be applied to
+// them before a bundle is simulated.
+type StateOverrides map[common.Address]StateOverride
+
+// Apply writes the overrides onto statedb. It is meant to be called once,
+// right after the simulation snapshot is taken.
+func (overrides StateOverrides) Apply(statedb *state.StateDB) {
+	for addr, override := range overrides {
+		if override.Balance != nil {
+			statedb.SetBalance(addr, uint256.MustFromBig(override.Balance), tracing.BalanceChangeUnspecified)
+		}
+		if override.Nonce != nil {
+			statedb.SetNonce(addr, *override.Nonce)
+		}
+		if override.Code != nil {
+			statedb.SetCode(addr, override.Code)
+		}
+		if override.State != nil {
+			statedb.SetStorage(addr, override.State)
+		}
+		for key, value := range override.StateDiff {
+			statedb.SetState(addr, key, value)
+		}
+	}
+}
+
+// StorageDiff is the before/after value of a single storage slot that was
+// touched during the execution of a transaction.
+type StorageDiff struct {
+	Before common.Hash
+	After  common.Hash
+}
+
+// AccountDiff captures everything that changed on a single account while a
+// transaction was executed.
+type AccountDiff struct {
+	BalanceBefore *big.Int
+	BalanceAfter  *big.Int
+	NonceBefore   uint64
+	NonceAfter    uint64
+	CodeBefore    []byte
+	CodeAfter     []byte
+	Storage       map[common.Hash]StorageDiff
+}
+
+// StateDiff is the aggregate of every account touched while simulating a
+// single transaction, keyed by account address.
+type StateDiff map[common.Address]*AccountDiff
+
+// TxSimulationResult is the outcome of simulating a single transaction of a
+// bundle.
+type TxSimulationResult struct {
+	Tx           *types.Transaction
+	Receipt      *types.Receipt
+	StateDiff    StateDiff
+	RevertReason []byte
+	Error        error
+}
+
+// CoinbaseDiff summarises the profitability of a bundle from the point of
+// view of the block producer.
+type CoinbaseDiff struct {
+	Address       common.Address
+	BalanceBefore *big.Int
+	BalanceAfter  *big.Int
+}
+
+// Profit returns the net balance gained by the coinbase across the bundle.
+func (c *CoinbaseDiff) Profit() *big.Int {
+	return new(big.Int).Sub(c.BalanceAfter, c.BalanceBefore)
+}
+
+// BundleResult is the aggregate result of simulating an entire bundle of
+// transactions on top of a replayed block prefix.
+type BundleResult struct {
+	TxResults []*TxSimulationResult
+	Coinbase  *CoinbaseDiff
+}
+
+// BundleSimulator replays a prefix of a block and then executes an unmined
+// bundle of transactions on top of it, collecting per-tx state diffs and an
+// aggregate coinbase diff. The prefix is replayed through the step-wise
+// MethSimulation API (PrepareEnv/ProcessTx), and bundle transactions run on
+// the same EVM and receipt-building code as ordinary block processing; it
+// never calls Commit, and bundle gas is counted in a per-attempt pool
+// seeded from the prefix rather than in the block's own pool.
+type BundleSimulator struct {
+	processor *StateProcessor
+	meth      *MethSimulation
+	statedb   *state.StateDB
+
+	// prefixGasRemaining and prefixUsedGas are snapshots of meth.gasPool and
+	// meth.usedGas taken right after the prefix finished replaying. Every
+	// SimulateBundle call starts its own GasPool/usedGas counter from these
+	// values instead of mutating meth's block-level ones directly, so that
+	// a failed attempt - or a second attempt trying an alternative bundle -
+	// never leaks gas accounting into the real block (or into meth, which
+	// may still go on to real ProcessTx/Commit calls for the block itself).
+	prefixGasRemaining uint64
+	prefixUsedGas      uint64
+
+	// prefixLen is the number of real block transactions replayed before
+	// this simulator's bundle txs start; it seeds the tx index SimulateBundle
+	// assigns to each bundle transaction via SetTxContext.
+	prefixLen int
+}
+
+// NewBundleSimulator replays the first prefixLen transactions of block onto
+// statedb via the ordinary MethSimulation path, and returns a
+// BundleSimulator ready to execute additional, unmined transactions on top
+// of that prefix.
+func NewBundleSimulator(p *StateProcessor, block *types.Block, statedb *state.StateDB, cfg vm.Config, prefixLen int) (*BundleSimulator, error) {
+	meth := p.PrepareEnv(block, statedb, cfg)
+	if meth == nil {
+		return nil, errParentNotFound
+	}
+	for i := 0; i < prefixLen; i++ {
+		if _, _, _, _, _, err := p.ProcessTx(meth, statedb, i); err != nil {
+			return nil, err
+		}
+	}
+	return &BundleSimulator{
+		processor:          p,
+		meth:               meth,
+		statedb:            statedb,
+		prefixGasRemaining: meth.gasPool.Gas(),
+		prefixUsedGas:      *meth.usedGas,
+		prefixLen:          prefixLen,
+	}, nil
+}
+
+// newAttemptGasState returns a fresh GasPool/usedGas counter pair seeded
+// from the prefix snapshot, independent of any other attempt's pair built
+// from the same snapshot. Factored out of SimulateBundle so the isolation
+// invariant can be exercised directly in tests without needing a real
+// MethSimulation/StateDB.
+func (b *BundleSimulator) newAttemptGasState() (*GasPool, uint64) {
+	return new(GasPool).AddGas(b.prefixGasRemaining), b.prefixUsedGas
+}
+
+// SimulateBundle applies overrides to a snapshot of the current state, then
+// executes every transaction in the bundle in order, returning a StateDiff
+// and revert reason per transaction plus the aggregate coinbase diff.
+// Execution is reverted back to the pre-bundle snapshot before returning,
+// so repeated calls can try alternative bundles against the same replayed
+// prefix.
+func (b *BundleSimulator) SimulateBundle(bundle []*types.Transaction, overrides StateOverrides) (*BundleResult, error) {
+	snapshot := b.statedb.Snapshot()
+	defer b.statedb.RevertToSnapshot(snapshot)
+
+	overrides.Apply(b.statedb)
+
+	coinbase := b.meth.header.Coinbase
+	coinbaseBefore := b.statedb.GetBalance(coinbase).ToBig()
+
+	// Per-attempt gas accounting, independent of meth's block-level pool so
+	// that this bundle attempt can never starve the real block or a later
+	// SimulateBundle call retrying a different bundle against this prefix.
+	gp, usedGas := b.newAttemptGasState()
+
+	result := &BundleResult{TxResults: make([]*TxSimulationResult, 0, len(bundle))}
+	for i, tx := range bundle {
+		result.TxResults = append(result.TxResults, b.simulateOne(tx, b.prefixLen+i, gp, &usedGas))
+	}
+
+	result.Coinbase = &CoinbaseDiff{
+		Address:       coinbase,
+		BalanceBefore: new(big.Int).Set(coinbaseBefore),
+		BalanceAfter:  b.statedb.GetBalance(coinbase).ToBig(),
+	}
+	return result, nil
+}
+
+// simulateOne executes a single bundle transaction against its own journal
+// checkpoint, recording the state diff of every account it touches. A
+// failing transaction reverts to the checkpoint but does not abort the
+// rest of the bundle.
+func (b *BundleSimulator) simulateOne(tx *types.Transaction, index int, gp *GasPool, usedGas *uint64) *TxSimulationResult {
+	msg, err := TransactionToMessage(tx, b.meth.signer, b.meth.header.BaseFee)
+	if err != nil {
+		return &TxSimulationResult{Tx: tx, Error: err}
+	}
+
+	// Without this, GetLogs inside applyTransactionResult keys off whatever
+	// tx hash statedb's context was last set to (the last prefix tx, or the
+	// zero hash), so every bundle tx's logs and receipt.TransactionIndex
+	// would come back wrong regardless of what it actually emitted.
+	b.statedb.SetTxContext(tx.Hash(), index)
+
+	diff := newDiffRecorder(b.statedb)
+	checkpoint := b.statedb.Snapshot()
+
+	prevTracer := b.meth.evm.Config.Tracer
+	b.meth.evm.Config.Tracer = diff.hooks()
+	// Deliberately not passing meth.bloomProcessors: it's the real block's
+	// shared async bloom generator, and feeding it a speculative bundle
+	// attempt's receipt would corrupt the real block's bloom once Commit
+	// runs, for the same reason meth.gasPool/meth.usedGas aren't used here.
+	receipt, execResult, err := applyTransactionResult(msg, b.processor.config, gp, b.statedb, b.meth.blockNumber, b.meth.blockHash, tx, usedGas, b.meth.evm)
+	b.meth.evm.Config.Tracer = prevTracer
+	if err != nil {
+		b.statedb.RevertToSnapshot(checkpoint)
+		return &TxSimulationResult{Tx: tx, Error: err}
+	}
+
+	result := &TxSimulationResult{Tx: tx, Receipt: receipt, StateDiff: diff.diff(b.statedb)}
+	if execResult.Failed() {
+		result.RevertReason = execResult.Revert()
+	}
+	return result
+}
+
+// GenerateAccessList runs tx once against a throwaway snapshot with a
+// touch-tracing EVM to collect every (address, slot) pair it reads or
+// writes, then returns the resulting access list. Callers typically
+// re-execute the real transaction with this access list attached so that
+// the warm-storage gas discount applies and the state cache is prewarmed
+// for the real execution.
+func (b *BundleSimulator) GenerateAccessList(tx *types.Transaction) (types.AccessList, error) {
+	msg, err := TransactionToMessage(tx, b.meth.signer, b.meth.header.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := b.statedb.Snapshot()
+	defer b.statedb.RevertToSnapshot(snapshot)
+
+	tracer := newAccessListTracer(msg.From, msg.To)
+	cfg := b.meth.evm.Config
+	cfg.Tracer = tracer.hooks()
+
+	traceEvm := vm.NewEVM(b.meth.evm.Context, NewEVMTxContext(msg), b.statedb, b.processor.config, cfg)
+	if _, err := ApplyMessage(traceEvm, msg, new(GasPool).AddGas(msg.GasLimit)); err != nil {
+		return nil, err
+	}
+	return tracer.accessList(), nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCoinbaseDiffProfit(t *testing.T) {
+	diff := &CoinbaseDiff{
+		Address:       common.HexToAddress("0x1"),
+		BalanceBefore: big.NewInt(100),
+		BalanceAfter:  big.NewInt(142),
+	}
+	if got := diff.Profit(); got.Cmp(big.NewInt(42)) != 0 {
+		t.Fatalf("Profit() = %v, want 42", got)
+	}
+}
+
+func TestCoinbaseDiffProfitNegativeOnLoss(t *testing.T) {
+	diff := &CoinbaseDiff{
+		Address:       common.HexToAddress("0x1"),
+		BalanceBefore: big.NewInt(100),
+		BalanceAfter:  big.NewInt(60),
+	}
+	if got := diff.Profit(); got.Sign() >= 0 {
+		t.Fatalf("Profit() = %v, want a negative delta", got)
+	}
+}
+
+// TestBundleSimulatorNewAttemptGasStateIsolatedFromPrefix exercises the
+// actual production code SimulateBundle calls for its per-attempt gas
+// accounting: b.newAttemptGasState() must hand back a fresh GasPool and
+// usedGas counter derived from the prefix snapshot every time, so draining
+// one attempt's pool - whether from a failing tx or a retried alternative
+// bundle - is never visible to a later attempt built from the same
+// BundleSimulator. A full BundleSimulator normally also needs a real
+// *state.StateDB and block/engine fixtures that this dependency-free tree
+// can't provide, but newAttemptGasState only reads the two snapshot fields,
+// so it can be called on a bare struct literal instead.
+func TestBundleSimulatorNewAttemptGasStateIsolatedFromPrefix(t *testing.T) {
+	b := &BundleSimulator{prefixGasRemaining: 1_000_000, prefixUsedGas: 21_000}
+
+	gp1, usedGas1 := b.newAttemptGasState()
+	if err := gp1.SubGas(900_000); err != nil {
+		t.Fatalf("SubGas on attempt1's pool failed: %v", err)
+	}
+	usedGas1 += 50_000
+
+	gp2, usedGas2 := b.newAttemptGasState()
+	if got := gp2.Gas(); got != b.prefixGasRemaining {
+		t.Fatalf("attempt2 gas = %d, want untouched prefix snapshot %d - draining attempt1's pool leaked into attempt2", got, b.prefixGasRemaining)
+	}
+	if usedGas2 != b.prefixUsedGas {
+		t.Fatalf("attempt2 usedGas = %d, want untouched prefix snapshot %d - advancing attempt1's counter leaked into attempt2", usedGas2, b.prefixUsedGas)
+	}
+	if usedGas1 != b.prefixUsedGas+50_000 {
+		t.Fatalf("attempt1 usedGas = %d, want %d", usedGas1, b.prefixUsedGas+50_000)
+	}
+}
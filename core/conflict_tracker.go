@@ -0,0 +1,225 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// touchSet is a set of accounts and storage slots touched while executing a
+// transaction.
+type touchSet struct {
+	accounts map[common.Address]struct{}
+	storage  map[common.Address]map[common.Hash]struct{}
+	// universal marks a touch set that must be treated as conflicting with
+	// every other touch set, for callers that can't (or don't bother to)
+	// enumerate the exact accounts involved - e.g. a PoSA system
+	// transaction, which is free to touch arbitrary system contract
+	// storage that the per-tx isParallelizable check can't see through
+	// internal calls.
+	universal bool
+}
+
+func newTouchSet() *touchSet {
+	return &touchSet{
+		accounts: make(map[common.Address]struct{}),
+		storage:  make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+// universalTouchSet returns a touch set that conflicts with every other
+// touch set it is compared against.
+func universalTouchSet() *touchSet {
+	return &touchSet{universal: true}
+}
+
+func (t *touchSet) addAccount(addr common.Address) {
+	t.accounts[addr] = struct{}{}
+}
+
+func (t *touchSet) addSlot(addr common.Address, slot common.Hash) {
+	if t.storage[addr] == nil {
+		t.storage[addr] = make(map[common.Hash]struct{})
+	}
+	t.storage[addr][slot] = struct{}{}
+}
+
+// intersects reports whether t shares a touched account or storage slot
+// with other. Conflicts are detected conservatively at account
+// granularity first (a nonce/balance/code touch on an account the other
+// set also touched always conflicts) and at slot granularity for storage.
+func (t *touchSet) intersects(other *touchSet) bool {
+	if t.universal || other.universal {
+		return true
+	}
+	for addr := range t.accounts {
+		if _, ok := other.accounts[addr]; ok {
+			return true
+		}
+	}
+	for addr, slots := range t.storage {
+		otherSlots, ok := other.storage[addr]
+		if !ok {
+			continue
+		}
+		for slot := range slots {
+			if _, ok := otherSlots[slot]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeSet is the final, post-execution value of every account and storage
+// slot a transaction wrote to. It is captured directly from the live
+// tracing hooks so that a non-conflicting speculative result can be
+// committed onto the authoritative StateDB without re-running the EVM.
+type writeSet struct {
+	balances   map[common.Address]*big.Int
+	nonces     map[common.Address]uint64
+	codes      map[common.Address][]byte
+	storage    map[common.Address]map[common.Hash]common.Hash
+	destructed map[common.Address]struct{}
+}
+
+func newWriteSet() *writeSet {
+	return &writeSet{
+		balances:   make(map[common.Address]*big.Int),
+		nonces:     make(map[common.Address]uint64),
+		codes:      make(map[common.Address][]byte),
+		storage:    make(map[common.Address]map[common.Hash]common.Hash),
+		destructed: make(map[common.Address]struct{}),
+	}
+}
+
+// apply writes every recorded value onto statedb. The caller must already
+// hold exclusive access to statedb (i.e. this runs on the sequential
+// commit path, never concurrently with another writer).
+//
+// Self-destructs are replayed last, after every other recorded write for
+// that address: SelfDestruct is what actually clears an account's balance,
+// nonce and code in statedb, so replaying it first would just have the
+// balance/nonce/code writes above resurrect a zombie account.
+func (w *writeSet) apply(statedb stateWriter) {
+	for addr, balance := range w.balances {
+		statedb.SetBalance(addr, uint256.MustFromBig(balance), tracing.BalanceChangeUnspecified)
+	}
+	for addr, nonce := range w.nonces {
+		statedb.SetNonce(addr, nonce)
+	}
+	for addr, code := range w.codes {
+		statedb.SetCode(addr, code)
+	}
+	for addr, slots := range w.storage {
+		for slot, value := range slots {
+			statedb.SetState(addr, slot, value)
+		}
+	}
+	for addr := range w.destructed {
+		statedb.SelfDestruct(addr)
+	}
+}
+
+// conflictTracker combines read/write-set tracking (for conflict
+// detection) and write-value recording (for lock-free commit of
+// non-conflicting results) into a single set of live tracing hooks that
+// can be attached to a speculative execution's vm.Config.
+type conflictTracker struct {
+	touched *touchSet
+	writes  *writeSet
+}
+
+func newConflictTracker() *conflictTracker {
+	return &conflictTracker{touched: newTouchSet(), writes: newWriteSet()}
+}
+
+func (c *conflictTracker) hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnBalanceChange: func(addr common.Address, before, after *big.Int, reason tracing.BalanceChangeReason) {
+			c.touched.addAccount(addr)
+			if reason == tracing.BalanceDecreaseSelfdestruct {
+				// The account is being torn down; record it so apply
+				// replays the deletion via statedb.SelfDestruct instead of
+				// leaving a zeroed-out balance entry, which would
+				// resurrect the account as an empty-but-existing one
+				// instead of actually deleting it.
+				c.writes.destructed[addr] = struct{}{}
+				delete(c.writes.balances, addr)
+				return
+			}
+			c.writes.balances[addr] = new(big.Int).Set(after)
+		},
+		OnNonceChange: func(addr common.Address, before, after uint64) {
+			c.touched.addAccount(addr)
+			c.writes.nonces[addr] = after
+		},
+		OnCodeChange: func(addr common.Address, prevCodeHash common.Hash, prevCode []byte, codeHash common.Hash, code []byte) {
+			c.touched.addAccount(addr)
+			c.writes.codes[addr] = code
+		},
+		OnStorageChange: func(addr common.Address, slot common.Hash, before, after common.Hash) {
+			c.touched.addSlot(addr, slot)
+			if c.writes.storage[addr] == nil {
+				c.writes.storage[addr] = make(map[common.Hash]common.Hash)
+			}
+			c.writes.storage[addr][slot] = after
+		},
+		// OnOpcode additionally records plain reads (SLOAD/BALANCE/EXTCODE*)
+		// that never produce a write callback, so that the read set used
+		// for conflict detection is not limited to what the tx wrote.
+		OnOpcode: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			stack := scope.StackData()
+			switch vm.OpCode(op) {
+			case vm.SLOAD:
+				if len(stack) >= 1 {
+					c.touched.addSlot(scope.Address(), common.Hash(stack[len(stack)-1].Bytes32()))
+				}
+			case vm.BALANCE, vm.EXTCODEHASH, vm.EXTCODESIZE, vm.EXTCODECOPY:
+				if len(stack) >= 1 {
+					c.touched.addAccount(common.Address(stack[len(stack)-1].Bytes20()))
+				}
+			case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+				// The callee must be in the touch set even if the call
+				// itself produces no observable balance/nonce/storage/code
+				// change on it: a later-conflicting tx that self-destructs
+				// and redeploys code at that address must still be detected
+				// as conflicting with this one, or this tx's speculative
+				// result (executed against stale code) could be wrongly
+				// accepted as non-conflicting.
+				if len(stack) >= 2 {
+					c.touched.addAccount(common.Address(stack[len(stack)-2].Bytes20()))
+				}
+			}
+		},
+	}
+}
+
+// stateWriter is the subset of state.StateDB's mutation API that writeSet
+// needs to replay a committed speculative result.
+type stateWriter interface {
+	SetBalance(common.Address, *uint256.Int, tracing.BalanceChangeReason)
+	SetNonce(common.Address, uint64)
+	SetCode(common.Address, []byte)
+	SetState(common.Address, common.Hash, common.Hash) common.Hash
+	SelfDestruct(common.Address) uint256.Int
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+func TestTouchSetIntersectsAccount(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+
+	a := newTouchSet()
+	a.addAccount(addr)
+	b := newTouchSet()
+	b.addAccount(addr)
+
+	if !a.intersects(b) || !b.intersects(a) {
+		t.Fatalf("touch sets sharing account %v should intersect", addr)
+	}
+}
+
+func TestTouchSetIntersectsSlot(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	slot := common.HexToHash("0x2")
+
+	a := newTouchSet()
+	a.addSlot(addr, slot)
+	b := newTouchSet()
+	b.addSlot(addr, common.HexToHash("0x3"))
+
+	if a.intersects(b) {
+		t.Fatalf("touch sets on disjoint slots of the same account should not intersect")
+	}
+
+	b.addSlot(addr, slot)
+	if !a.intersects(b) {
+		t.Fatalf("touch sets sharing slot %v of account %v should intersect", slot, addr)
+	}
+}
+
+func TestTouchSetNoIntersection(t *testing.T) {
+	a := newTouchSet()
+	a.addAccount(common.HexToAddress("0x1"))
+	b := newTouchSet()
+	b.addAccount(common.HexToAddress("0x2"))
+
+	if a.intersects(b) || b.intersects(a) {
+		t.Fatalf("touch sets on disjoint accounts should not intersect")
+	}
+}
+
+func TestUniversalTouchSetAlwaysIntersects(t *testing.T) {
+	universal := universalTouchSet()
+	other := newTouchSet()
+	other.addAccount(common.HexToAddress("0x1"))
+
+	if !universal.intersects(other) || !other.intersects(universal) {
+		t.Fatalf("a universal touch set must conflict with every other touch set")
+	}
+
+	empty := newTouchSet()
+	if !universal.intersects(empty) {
+		t.Fatalf("a universal touch set must conflict even with an empty touch set")
+	}
+}
+
+func TestConflictsWithAny(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	touched := newTouchSet()
+	touched.addAccount(addr)
+
+	committed := []*touchSet{
+		newTouchSet(),
+		func() *touchSet { ts := newTouchSet(); ts.addAccount(common.HexToAddress("0x2")); return ts }(),
+	}
+	if conflictsWithAny(touched, committed) {
+		t.Fatalf("conflictsWithAny = true, want false: no committed set touches %v", addr)
+	}
+
+	committed = append(committed, universalTouchSet())
+	if !conflictsWithAny(touched, committed) {
+		t.Fatalf("conflictsWithAny = false, want true: a universal touch set was committed")
+	}
+}
+
+// fakeStateWriter records the calls writeSet.apply makes, in order, so tests
+// can assert both which values were written and the order they landed in.
+type fakeStateWriter struct {
+	calls []string
+}
+
+func (f *fakeStateWriter) SetBalance(addr common.Address, balance *uint256.Int, reason tracing.BalanceChangeReason) {
+	f.calls = append(f.calls, "SetBalance("+addr.Hex()+","+balance.String()+")")
+}
+
+func (f *fakeStateWriter) SetNonce(addr common.Address, nonce uint64) {
+	f.calls = append(f.calls, "SetNonce")
+}
+
+func (f *fakeStateWriter) SetCode(addr common.Address, code []byte) {
+	f.calls = append(f.calls, "SetCode")
+}
+
+func (f *fakeStateWriter) SetState(addr common.Address, slot, value common.Hash) common.Hash {
+	f.calls = append(f.calls, "SetState")
+	return common.Hash{}
+}
+
+func (f *fakeStateWriter) SelfDestruct(addr common.Address) uint256.Int {
+	f.calls = append(f.calls, "SelfDestruct("+addr.Hex()+")")
+	return uint256.Int{}
+}
+
+func TestWriteSetApplyReplaysSelfDestructAfterOtherWrites(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+
+	ws := newWriteSet()
+	ws.balances[addr] = big.NewInt(100)
+	ws.nonces[addr] = 1
+	ws.destructed[addr] = struct{}{}
+
+	w := &fakeStateWriter{}
+	ws.apply(w)
+
+	if len(w.calls) != 3 {
+		t.Fatalf("apply made %d calls, want 3: %v", len(w.calls), w.calls)
+	}
+	if last := w.calls[len(w.calls)-1]; last != "SelfDestruct("+addr.Hex()+")" {
+		t.Fatalf("last call = %q, want the SelfDestruct call to be replayed last so it isn't undone by the balance/nonce writes above", last)
+	}
+}
+
+// fakeOpContext is a minimal tracing.OpContext stub: only StackData and
+// Address are exercised by conflictTracker's OnOpcode hook.
+type fakeOpContext struct {
+	addr  common.Address
+	stack []uint256.Int
+}
+
+func (f *fakeOpContext) MemoryData() []byte          { return nil }
+func (f *fakeOpContext) StackData() []uint256.Int    { return f.stack }
+func (f *fakeOpContext) Caller() common.Address      { return common.Address{} }
+func (f *fakeOpContext) Address() common.Address     { return f.addr }
+func (f *fakeOpContext) CallValue() *uint256.Int     { return new(uint256.Int) }
+func (f *fakeOpContext) CallInput() []byte           { return nil }
+func (f *fakeOpContext) ContractCode() []byte        { return nil }
+
+func TestConflictTrackerRecordsCallTargets(t *testing.T) {
+	callee := common.HexToAddress("0x2")
+
+	// EVM stack top-of-stack is the last element; CALL/CALLCODE/DELEGATECALL/
+	// STATICCALL push gas on top of the callee address, matching
+	// access_list_tracer.go's stack[len(stack)-2] convention.
+	stack := []uint256.Int{
+		*new(uint256.Int).SetBytes(callee.Bytes()), // address
+		*uint256.NewInt(100000),                    // gas
+	}
+	scope := &fakeOpContext{addr: common.HexToAddress("0x1"), stack: stack}
+
+	for _, op := range []vm.OpCode{vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL} {
+		tracker := newConflictTracker()
+		tracker.hooks().OnOpcode(0, byte(op), 0, 0, scope, nil, 1, nil)
+		if _, ok := tracker.touched.accounts[callee]; !ok {
+			t.Fatalf("%s: callee %v was not recorded in the touch set", op, callee)
+		}
+	}
+}
+
+func TestConflictTrackerRecordsSelfdestructNotBalance(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	tracker := newConflictTracker()
+
+	hooks := tracker.hooks()
+	hooks.OnBalanceChange(addr, big.NewInt(100), big.NewInt(0), tracing.BalanceDecreaseSelfdestruct)
+
+	if _, ok := tracker.writes.destructed[addr]; !ok {
+		t.Fatalf("OnBalanceChange with BalanceDecreaseSelfdestruct did not record %v as destructed", addr)
+	}
+	if _, ok := tracker.writes.balances[addr]; ok {
+		t.Fatalf("a destructed account should not also have a plain balance write recorded")
+	}
+	if _, ok := tracker.touched.accounts[addr]; !ok {
+		t.Fatalf("a destructed account must still be recorded in the touch set")
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// errParentNotFound is returned when the parent of the block being
+// simulated cannot be found in the canonical chain.
+var errParentNotFound = errors.New("parent block not found")
+
+// Note: ErrNonceTooLow, ErrNonceTooHigh, ErrGasLimitReached,
+// ErrInsufficientFundsForTransfer, ErrInsufficientFunds, ErrIntrinsicGas,
+// ErrTxTypeNotSupported and friends are already declared as sentinels in
+// state_transition.go and returned from ApplyMessage; BlockValidationError
+// below wraps whatever state_transition.go returns without redeclaring it,
+// so errors.Is(err, ErrNonceTooLow) keeps working through Unwrap.
+
+// BlockValidationError wraps an error encountered while applying a single
+// transaction with the block and transaction context it occurred in, so
+// that callers can pinpoint exactly which transaction failed and use
+// errors.Is/errors.As on the wrapped cause instead of parsing the error
+// string.
+type BlockValidationError struct {
+	BlockNumber *big.Int
+	BlockHash   common.Hash
+	TxIndex     int
+	TxHash      common.Hash
+	Err         error
+}
+
+// Error implements the error interface.
+func (e *BlockValidationError) Error() string {
+	return fmt.Sprintf("could not apply tx %d [%s] in block %v (%s): %v",
+		e.TxIndex, e.TxHash.Hex(), e.BlockNumber, e.BlockHash.Hex(), e.Err)
+}
+
+// Unwrap returns the underlying cause so that errors.Is/errors.As can see
+// through the block/tx context to sentinel errors such as ErrNonceTooLow.
+func (e *BlockValidationError) Unwrap() error {
+	return e.Err
+}
+
+// newBlockValidationError wraps err with the context of the block and
+// transaction being processed. If err is nil, it returns nil.
+func newBlockValidationError(err error, blockNumber *big.Int, blockHash common.Hash, txIndex int, txHash common.Hash) error {
+	if err == nil {
+		return nil
+	}
+	return &BlockValidationError{
+		BlockNumber: blockNumber,
+		BlockHash:   blockHash,
+		TxIndex:     txIndex,
+		TxHash:      txHash,
+		Err:         err,
+	}
+}
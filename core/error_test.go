@@ -0,0 +1,47 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBlockValidationErrorUnwrapsToSentinel(t *testing.T) {
+	cause := errors.New("nonce too low")
+	wrapped := newBlockValidationError(cause, big.NewInt(5), common.HexToHash("0x1"), 3, common.HexToHash("0x2"))
+
+	if !errors.Is(wrapped, cause) {
+		t.Fatalf("errors.Is(wrapped, cause) = false, want true")
+	}
+	var bve *BlockValidationError
+	if !errors.As(wrapped, &bve) {
+		t.Fatalf("errors.As did not find a *BlockValidationError")
+	}
+	if bve.TxIndex != 3 || bve.BlockNumber.Cmp(big.NewInt(5)) != 0 {
+		t.Fatalf("unexpected context on wrapped error: %+v", bve)
+	}
+}
+
+func TestNewBlockValidationErrorNilIsNil(t *testing.T) {
+	if err := newBlockValidationError(nil, big.NewInt(1), common.Hash{}, 0, common.Hash{}); err != nil {
+		t.Fatalf("newBlockValidationError(nil, ...) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Message is a plain, fully-exported description of a transaction's EVM
+// inputs. It has no behaviour of its own; ApplyMessage reads its fields
+// directly, so external callers (bundle simulators, eth_call-style RPC
+// handlers) can construct one without going through a signed
+// *types.Transaction at all.
+type Message struct {
+	To         *common.Address
+	From       common.Address
+	Nonce      uint64
+	Value      *big.Int
+	GasLimit   uint64
+	GasPrice   *big.Int
+	GasFeeCap  *big.Int
+	GasTipCap  *big.Int
+	Data       []byte
+	AccessList types.AccessList
+
+	BlobHashes    []common.Hash
+	BlobGasFeeCap *big.Int
+
+	// SkipAccountChecks disables the nonce and EOA checks normally done by
+	// the state transition. It is useful for EVM simulation without
+	// requiring a valid signature, e.g. impersonated sends or gasless
+	// eth_call-style estimation.
+	SkipAccountChecks bool
+}
+
+// TransactionToMessage converts a signed transaction into a Message for use
+// by StateTransition, deriving From from the transaction's signature.
+func TransactionToMessage(tx *types.Transaction, s types.Signer, baseFee *big.Int) (*Message, error) {
+	msg := &Message{
+		Nonce:             tx.Nonce(),
+		GasLimit:          tx.Gas(),
+		GasPrice:          new(big.Int).Set(tx.GasPrice()),
+		GasFeeCap:         new(big.Int).Set(tx.GasFeeCap()),
+		GasTipCap:         new(big.Int).Set(tx.GasTipCap()),
+		To:                tx.To(),
+		Value:             tx.Value(),
+		Data:              tx.Data(),
+		AccessList:        tx.AccessList(),
+		SkipAccountChecks: false,
+		BlobHashes:        tx.BlobHashes(),
+		BlobGasFeeCap:     tx.BlobGasFeeCap(),
+	}
+	// If baseFee provided, set gasPrice to effectiveGasPrice.
+	if baseFee != nil {
+		msg.GasPrice = new(big.Int).Add(msg.GasTipCap, baseFee)
+		if msg.GasPrice.Cmp(msg.GasFeeCap) > 0 {
+			msg.GasPrice = msg.GasFeeCap
+		}
+	}
+	var err error
+	msg.From, err = types.Sender(s, tx)
+	return msg, err
+}
+
+// WithFrom returns a copy of msg with From overridden, leaving the
+// signature-derived message untouched. It is the ergonomic way to
+// impersonate a sender in a simulated message.
+func (msg *Message) WithFrom(from common.Address) *Message {
+	clone := *msg
+	clone.From = from
+	return &clone
+}
+
+// WithGasPrice returns a copy of msg with GasPrice overridden; GasFeeCap
+// and GasTipCap are left unchanged. It is the ergonomic way to reprice a
+// simulated message without hand-building a Message.
+func (msg *Message) WithGasPrice(gasPrice *big.Int) *Message {
+	clone := *msg
+	clone.GasPrice = gasPrice
+	return &clone
+}
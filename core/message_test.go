@@ -0,0 +1,136 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func signedDynamicFeeTx(t *testing.T, signer types.Signer, nonce uint64, gasFeeCap, gasTipCap *big.Int) *types.Transaction {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+		ChainID:   signer.ChainID(),
+		Nonce:     nonce,
+		GasFeeCap: gasFeeCap,
+		GasTipCap: gasTipCap,
+		Gas:       21000,
+		To:        &common.Address{},
+		Value:     big.NewInt(0),
+	})
+	if err != nil {
+		t.Fatalf("SignNewTx failed: %v", err)
+	}
+	return tx
+}
+
+func signedLegacyTx(t *testing.T, signer types.Signer, nonce uint64, gasPrice *big.Int) *types.Transaction {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: gasPrice,
+		Gas:      21000,
+		To:       &common.Address{},
+		Value:    big.NewInt(0),
+	})
+	if err != nil {
+		t.Fatalf("SignNewTx failed: %v", err)
+	}
+	return tx
+}
+
+func TestTransactionToMessageEffectiveGasPriceCappedByFeeCap(t *testing.T) {
+	signer := types.NewLondonSigner(big.NewInt(1))
+	tx := signedDynamicFeeTx(t, signer, 0, big.NewInt(100), big.NewInt(80))
+
+	msg, err := TransactionToMessage(tx, signer, big.NewInt(50))
+	if err != nil {
+		t.Fatalf("TransactionToMessage failed: %v", err)
+	}
+	// tip + baseFee (80 + 50 = 130) exceeds GasFeeCap (100), so GasPrice must
+	// be capped at GasFeeCap rather than the uncapped sum.
+	if msg.GasPrice.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("GasPrice = %v, want capped at GasFeeCap 100", msg.GasPrice)
+	}
+}
+
+func TestTransactionToMessageEffectiveGasPriceUncapped(t *testing.T) {
+	signer := types.NewLondonSigner(big.NewInt(1))
+	tx := signedDynamicFeeTx(t, signer, 0, big.NewInt(100), big.NewInt(20))
+
+	msg, err := TransactionToMessage(tx, signer, big.NewInt(50))
+	if err != nil {
+		t.Fatalf("TransactionToMessage failed: %v", err)
+	}
+	// tip + baseFee (20 + 50 = 70) stays under GasFeeCap (100), so GasPrice
+	// should be the uncapped sum.
+	if msg.GasPrice.Cmp(big.NewInt(70)) != 0 {
+		t.Fatalf("GasPrice = %v, want uncapped tip+baseFee 70", msg.GasPrice)
+	}
+}
+
+func TestTransactionToMessageNoBaseFeeUsesGasPrice(t *testing.T) {
+	signer := types.HomesteadSigner{}
+	tx := signedLegacyTx(t, signer, 0, big.NewInt(55))
+
+	msg, err := TransactionToMessage(tx, signer, nil)
+	if err != nil {
+		t.Fatalf("TransactionToMessage failed: %v", err)
+	}
+	if msg.GasPrice.Cmp(big.NewInt(55)) != 0 {
+		t.Fatalf("GasPrice = %v, want the tx's own GasPrice 55 when baseFee is nil", msg.GasPrice)
+	}
+}
+
+func TestMessageWithFromLeavesOriginalUntouched(t *testing.T) {
+	original := &Message{From: common.HexToAddress("0x1")}
+	impersonated := common.HexToAddress("0x2")
+
+	clone := original.WithFrom(impersonated)
+
+	if clone.From != impersonated {
+		t.Fatalf("clone.From = %v, want %v", clone.From, impersonated)
+	}
+	if original.From != common.HexToAddress("0x1") {
+		t.Fatalf("original.From = %v, want unchanged 0x1", original.From)
+	}
+}
+
+func TestMessageWithGasPriceLeavesOriginalUntouched(t *testing.T) {
+	original := &Message{GasPrice: big.NewInt(10)}
+
+	clone := original.WithGasPrice(big.NewInt(99))
+
+	if clone.GasPrice.Cmp(big.NewInt(99)) != 0 {
+		t.Fatalf("clone.GasPrice = %v, want 99", clone.GasPrice)
+	}
+	if original.GasPrice.Cmp(big.NewInt(10)) != 0 {
+		t.Fatalf("original.GasPrice = %v, want unchanged 10", original.GasPrice)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/systemcontracts"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// StateProcessorOption configures optional behaviour of a StateProcessor.
+type StateProcessorOption func(*StateProcessor)
+
+// WithParallelExecution opts a StateProcessor into Process's parallel mode:
+// non-system transactions that don't touch the coinbase or a system
+// contract are speculatively executed on concurrent per-tx StateDB
+// snapshots, then committed in original block order. Any transaction whose
+// touch set conflicts with an earlier-committed transaction's touch set is
+// discarded and re-executed on the real StateDB instead. workers bounds
+// the number of transactions speculated on concurrently.
+func WithParallelExecution(workers int) StateProcessorOption {
+	if workers <= 0 {
+		workers = 1
+	}
+	return func(p *StateProcessor) {
+		p.parallel = true
+		p.parallelWorkers = workers
+	}
+}
+
+// speculativeResult is the outcome of speculatively executing one
+// transaction against its own private copy of the block's starting state.
+type speculativeResult struct {
+	msg     *Message
+	touched *touchSet
+	writes  *writeSet
+	receipt *types.Receipt
+	err     error
+}
+
+// isParallelizable reports whether tx is eligible for speculative
+// execution: it must not call the coinbase or a well-known system
+// contract, since those always need to observe the effects of every
+// earlier transaction in the block. It does not check for PoSA system
+// transactions; processParallel filters those out before calling it.
+func isParallelizable(tx *types.Transaction, coinbase common.Address) bool {
+	to := tx.To()
+	if to == nil {
+		return true // contract creation never touches an existing system contract
+	}
+	if *to == coinbase {
+		return false
+	}
+	return !systemcontracts.IsSystemContract(*to)
+}
+
+// processParallel is the parallel counterpart of Process: it speculatively
+// executes eligible transactions concurrently, then commits every
+// transaction in original block order, re-executing any transaction whose
+// touch set conflicts with an already-committed one. Receipts,
+// CumulativeGasUsed, logs and SetTxContext indexing are identical to the
+// sequential path; only the scheduling of the underlying EVM work differs.
+func (p *StateProcessor) processParallel(block *types.Block, statedb *state.StateDB, cfg vm.Config) (*state.StateDB, types.Receipts, []*types.Log, uint64, error) {
+	var (
+		usedGas     = new(uint64)
+		header      = block.Header()
+		blockHash   = block.Hash()
+		blockNumber = block.Number()
+		allLogs     []*types.Log
+		gp          = new(GasPool).AddGas(block.GasLimit())
+		signer      = types.MakeSigner(p.config, header.Number, header.Time)
+		txs         = block.Transactions()
+		txNum       = len(txs)
+		receipts    = make([]*types.Receipt, txNum)
+		commonTxs   = make([]*types.Transaction, 0, txNum)
+		systemTxs   = make([]*types.Transaction, 0, 2)
+	)
+
+	// Mutate the block and state according to any hard-fork specs
+	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	// Handle upgrade build-in system contract code
+	lastBlock := p.bc.GetBlockByHash(block.ParentHash())
+	if lastBlock == nil {
+		return statedb, nil, nil, 0, fmt.Errorf("could not get parent block")
+	}
+	systemcontracts.UpgradeBuildInSystemContract(p.config, blockNumber, lastBlock.Time(), block.Time(), statedb)
+
+	posa, isPoSA := p.engine.(consensus.PoSA)
+	bloomProcessors := NewAsyncReceiptBloomGenerator(txNum)
+	statedb.MarkFullProcessed()
+
+	// Classify every transaction up front: system transactions and those
+	// touching the coinbase or a system contract always run sequentially.
+	eligible := make([]int, 0, txNum)
+	for i, tx := range txs {
+		if isPoSA {
+			isSystemTx, err := posa.IsSystemTransaction(tx, header)
+			if err != nil {
+				bloomProcessors.Close()
+				return statedb, nil, nil, 0, newBlockValidationError(err, blockNumber, blockHash, i, tx.Hash())
+			}
+			if isSystemTx {
+				continue
+			}
+		}
+		if isParallelizable(tx, header.Coinbase) {
+			eligible = append(eligible, i)
+		}
+	}
+
+	// Speculatively execute the eligible transactions concurrently, each
+	// against its own snapshot of the state the block started with.
+	results := make([]*speculativeResult, txNum)
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, p.parallelWorkers)
+	)
+	for _, i := range eligible {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = p.speculate(header, blockNumber, blockHash, txs[i], i, signer, statedb.Copy(), cfg)
+		}(i)
+	}
+	wg.Wait()
+
+	// Commit in original block order. A non-conflicting speculative result
+	// is applied directly; everything else (system txs, non-eligible txs,
+	// and conflicting speculative results) is executed sequentially on the
+	// real StateDB exactly as Process would.
+	//
+	// Soundness of accepting a non-conflicting speculative result without
+	// re-running it rests entirely on every earlier transaction's touch
+	// set - system or regular, speculative or sequential - being recorded
+	// into committed accurately: a speculative result was computed against
+	// the state the block *started* with, so it is only safe to accept
+	// as-is when nothing that ran before it (in commit order) touched
+	// anything it read or wrote. If that bookkeeping were incomplete, a
+	// stale speculative result could be accepted.
+	context := NewEVMBlockContext(header, p.bc, nil)
+	vmenv := vm.NewEVM(context, vm.TxContext{}, statedb, p.config, cfg)
+	committed := make([]*touchSet, 0, txNum)
+
+	// runningLogIndex is the true cumulative log count of every transaction
+	// committed so far, tracked independently of statedb's own internal log
+	// counter. That counter only observes logs from transactions actually
+	// run against the real statedb (the fallback path below); an accepted
+	// speculative result's logs were produced on a throwaway statedb.Copy()
+	// that started counting from zero, so neither side can be trusted once
+	// the block mixes accepted and fallback transactions - every Log.Index
+	// must be renumbered here to match what the sequential path would have
+	// produced.
+	var runningLogIndex uint
+
+	for i, tx := range txs {
+		if isPoSA {
+			if isSystemTx, _ := posa.IsSystemTransaction(tx, header); isSystemTx {
+				systemTxs = append(systemTxs, tx)
+				// System transactions aren't applied here (Finalize applies
+				// them after this loop, same as the sequential path), but
+				// they may still touch arbitrary system contracts, so any
+				// eligible tx that speculatively read/wrote a system
+				// contract must be forced to conflict rather than silently
+				// assumed independent.
+				committed = append(committed, universalTouchSet())
+				continue
+			}
+		}
+
+		res := results[i]
+		accept := res != nil && res.err == nil && !conflictsWithAny(res.touched, committed)
+
+		var (
+			receipt *types.Receipt
+			touched *touchSet
+			err     error
+		)
+		if accept {
+			if err = gp.SubGas(res.receipt.GasUsed); err != nil {
+				bloomProcessors.Close()
+				return statedb, nil, nil, 0, newBlockValidationError(err, blockNumber, blockHash, i, tx.Hash())
+			}
+			statedb.SetTxContext(tx.Hash(), i)
+			res.writes.apply(statedb)
+			receipt = res.receipt
+			receipt.CumulativeGasUsed = *usedGas + receipt.GasUsed
+			bloomProcessors.Apply(receipt)
+			touched = res.touched
+		} else {
+			msg, merr := TransactionToMessage(tx, signer, header.BaseFee)
+			if merr != nil {
+				bloomProcessors.Close()
+				return statedb, nil, nil, 0, newBlockValidationError(merr, blockNumber, blockHash, i, tx.Hash())
+			}
+			statedb.SetTxContext(tx.Hash(), i)
+			// Track this fallback execution's real touch set too: a later
+			// tx's conflict check must see what this one actually touched,
+			// not an empty placeholder, or it could be wrongly accepted
+			// from a speculative run that collides with it.
+			tracker := newConflictTracker()
+			prevTracer := vmenv.Config.Tracer
+			vmenv.Config.Tracer = tracker.hooks()
+			receipt, err = applyTransaction(msg, p.config, gp, statedb, blockNumber, blockHash, tx, usedGas, vmenv, bloomProcessors)
+			vmenv.Config.Tracer = prevTracer
+			touched = tracker.touched
+		}
+		if err != nil {
+			bloomProcessors.Close()
+			return statedb, nil, nil, 0, err
+		}
+		for j, log := range receipt.Logs {
+			log.Index = runningLogIndex + uint(j)
+		}
+		runningLogIndex += uint(len(receipt.Logs))
+		*usedGas = receipt.CumulativeGasUsed
+		receipts[i] = receipt
+		commonTxs = append(commonTxs, tx)
+		committed = append(committed, touched)
+	}
+	bloomProcessors.Close()
+
+	withdrawals := block.Withdrawals()
+	if len(withdrawals) > 0 && !p.config.IsShanghai(block.Number(), block.Time()) {
+		return nil, nil, nil, 0, errWithdrawalsBeforeShanghai
+	}
+
+	if err := p.engine.Finalize(p.bc, header, statedb, &commonTxs, block.Uncles(), withdrawals, &receipts, &systemTxs, usedGas); err != nil {
+		return statedb, receipts, allLogs, *usedGas, err
+	}
+	for _, receipt := range receipts {
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	return statedb, receipts, allLogs, *usedGas, nil
+}
+
+// speculate executes a single transaction against its own private copy of
+// statedb, recording its touch set and final write values via
+// conflictTracker so that the commit pass can either apply the writes
+// directly or discard them and re-execute on conflict.
+func (p *StateProcessor) speculate(header *types.Header, blockNumber *big.Int, blockHash common.Hash, tx *types.Transaction, index int, signer types.Signer, statedb *state.StateDB, cfg vm.Config) *speculativeResult {
+	msg, err := TransactionToMessage(tx, signer, header.BaseFee)
+	if err != nil {
+		return &speculativeResult{err: err}
+	}
+
+	tracker := newConflictTracker()
+	cfg.Tracer = tracker.hooks()
+
+	context := NewEVMBlockContext(header, p.bc, nil)
+	vmenv := vm.NewEVM(context, vm.TxContext{}, statedb, p.config, cfg)
+	statedb.SetTxContext(tx.Hash(), index)
+
+	usedGas := new(uint64)
+	receipt, err := applyTransaction(msg, p.config, new(GasPool).AddGas(header.GasLimit), statedb, blockNumber, blockHash, tx, usedGas, vmenv)
+
+	return &speculativeResult{msg: msg, touched: tracker.touched, writes: tracker.writes, receipt: receipt, err: err}
+}
+
+// conflictsWithAny reports whether touched intersects any of the
+// already-committed touch sets.
+func conflictsWithAny(touched *touchSet, committed []*touchSet) bool {
+	for _, w := range committed {
+		if touched.intersects(w) {
+			return true
+		}
+	}
+	return false
+}
+
+var errWithdrawalsBeforeShanghai = errors.New("withdrawals before shanghai")
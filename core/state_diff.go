@@ -0,0 +1,103 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+)
+
+// diffRecorder hooks into an EVM's live tracing callbacks to remember the
+// first value seen for every balance, nonce, code and storage slot that is
+// touched, so that a StateDiff can be produced once execution finishes.
+type diffRecorder struct {
+	accounts map[common.Address]*AccountDiff
+}
+
+// newDiffRecorder prepares the tracing hooks used by simulateOne. Hooks are
+// attached to the simulator's EVM by the caller before the message is
+// applied, and detached again once the message has been applied.
+func newDiffRecorder(statedb *state.StateDB) *diffRecorder {
+	return &diffRecorder{accounts: make(map[common.Address]*AccountDiff)}
+}
+
+func (d *diffRecorder) account(addr common.Address) *AccountDiff {
+	acc, ok := d.accounts[addr]
+	if !ok {
+		acc = &AccountDiff{Storage: make(map[common.Hash]StorageDiff)}
+		d.accounts[addr] = acc
+	}
+	return acc
+}
+
+// hooks returns the tracing.Hooks that feed this recorder. Attach them to
+// vm.Config.Tracer before execution.
+func (d *diffRecorder) hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnBalanceChange: func(addr common.Address, before, after *big.Int, reason tracing.BalanceChangeReason) {
+			acc := d.account(addr)
+			if acc.BalanceBefore == nil {
+				acc.BalanceBefore = new(big.Int).Set(before)
+			}
+			acc.BalanceAfter = new(big.Int).Set(after)
+		},
+		OnNonceChange: func(addr common.Address, before, after uint64) {
+			acc := d.account(addr)
+			// Nonces only grow during execution, so a zero NonceAfter means
+			// no nonce change has been recorded for addr yet.
+			if acc.NonceAfter == 0 {
+				acc.NonceBefore = before
+			}
+			acc.NonceAfter = after
+		},
+		OnCodeChange: func(addr common.Address, prevCodeHash common.Hash, prevCode []byte, codeHash common.Hash, code []byte) {
+			acc := d.account(addr)
+			if acc.CodeBefore == nil {
+				acc.CodeBefore = prevCode
+			}
+			acc.CodeAfter = code
+		},
+		OnStorageChange: func(addr common.Address, slot common.Hash, before, after common.Hash) {
+			acc := d.account(addr)
+			existing, seen := acc.Storage[slot]
+			if !seen {
+				existing.Before = before
+			}
+			existing.After = after
+			acc.Storage[slot] = existing
+		},
+	}
+}
+
+// diff materialises the recorded changes into a StateDiff. For an account
+// whose balance did not change (only its nonce, code or storage did), both
+// balance values are filled in from the live state. Accounts that were
+// only read are not included.
+func (d *diffRecorder) diff(statedb *state.StateDB) StateDiff {
+	out := make(StateDiff, len(d.accounts))
+	for addr, acc := range d.accounts {
+		if acc.BalanceAfter == nil {
+			acc.BalanceAfter = statedb.GetBalance(addr).ToBig()
+			acc.BalanceBefore = new(big.Int).Set(acc.BalanceAfter)
+		}
+		out[addr] = acc
+	}
+	return out
+}
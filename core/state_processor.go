@@ -40,18 +40,26 @@ type StateProcessor struct {
 	config *params.ChainConfig // Chain configuration options
 	bc     *BlockChain         // Canonical block chain
 	engine consensus.Engine    // Consensus engine used for block rewards
+
+	parallel        bool // speculatively execute independent txs in Process, see WithParallelExecution
+	parallelWorkers int  // max number of txs speculated on concurrently
 }
 
 // NewStateProcessor initialises a new StateProcessor.
-func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consensus.Engine) *StateProcessor {
-	return &StateProcessor{
+func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consensus.Engine, opts ...StateProcessorOption) *StateProcessor {
+	p := &StateProcessor{
 		config: config,
 		bc:     bc,
 		engine: engine,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 type MethSimulation struct {
+	config          *params.ChainConfig
 	header          *types.Header
 	block           *types.Block
 	blockHash       common.Hash
@@ -106,6 +114,7 @@ func (p *StateProcessor) PrepareEnv(block *types.Block, statedb *state.StateDB,
 	var usedGas uint64 = 0
 
 	meth := MethSimulation{
+		config:          p.config,
 		block:           block,
 		header:          block.Header(),
 		blockHash:       block.Hash(),
@@ -142,7 +151,7 @@ func (p *StateProcessor) ProcessTx(meth *MethSimulation, statedb *state.StateDB,
 	if isPoSA {
 		if isSystemTx, err := posa.IsSystemTransaction(tx, block.Header()); err != nil {
 			bloomProcessors.Close()
-			return statedb, nil, nil, 0, &i, err
+			return statedb, nil, nil, 0, &i, newBlockValidationError(err, blockNumber, blockHash, i, tx.Hash())
 		} else if isSystemTx {
 			meth.systemTxs = append(systemTxs, tx)
 			return statedb, nil, nil, 0, &nextTxIndex, nil
@@ -152,14 +161,16 @@ func (p *StateProcessor) ProcessTx(meth *MethSimulation, statedb *state.StateDB,
 	msg, err := TransactionToMessage(tx, signer, header.BaseFee)
 	if err != nil {
 		bloomProcessors.Close()
-		return statedb, nil, nil, 0, &i, err
+		return statedb, nil, nil, 0, &i, newBlockValidationError(err, blockNumber, blockHash, i, tx.Hash())
 	}
 	statedb.SetTxContext(tx.Hash(), i)
 
 	receipt, err := applyTransaction(msg, p.config, gp, statedb, blockNumber, blockHash, tx, usedGas, vmenv, bloomProcessors)
 	if err != nil {
+		// applyTransaction already wraps ApplyMessage failures in a
+		// *BlockValidationError; don't wrap it a second time here.
 		bloomProcessors.Close()
-		return statedb, nil, nil, 0, &i, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		return statedb, nil, nil, 0, &i, err
 	}
 
 	meth.commonTxs = append(commonTxs, tx)
@@ -169,6 +180,37 @@ func (p *StateProcessor) ProcessTx(meth *MethSimulation, statedb *state.StateDB,
 
 }
 
+// ProcessMessage applies a synthesized Message rather than one derived from
+// a signed block transaction, reusing the same EVM, gas pool, bloom
+// processor and receipt pipeline that ProcessTx uses for real block
+// transactions. This lets external callers (bundle simulators, RPC
+// eth_call-style tools) synthesize messages - impersonated sends, gasless
+// simulations with SkipAccountChecks, or messages built from unsigned tx
+// templates via WithFrom/WithGasPrice - without needing a signed
+// *types.Transaction of their own. tx supplies the receipt's hash and type,
+// the nonce used to derive a created contract's address, and the key of
+// the statedb logs, and it is appended to the transactions handed to
+// Finalize on Commit; callers without a real transaction may pass a
+// synthetic, unsigned one built from the message fields. The resulting
+// receipt and logs are appended to the simulation's running state exactly
+// as ProcessTx would, so callers can freely mix ProcessTx and
+// ProcessMessage calls within the same MethSimulation.
+func (meth *MethSimulation) ProcessMessage(statedb *state.StateDB, msg *Message, tx *types.Transaction) (*types.Receipt, error) {
+	index := statedb.TxIndex()
+	statedb.SetTxContext(tx.Hash(), index)
+
+	receipt, err := applyTransaction(msg, meth.config, meth.gasPool, statedb, meth.blockNumber, meth.blockHash, tx, meth.usedGas, meth.evm, meth.bloomProcessors)
+	if err != nil {
+		// applyTransaction already wraps ApplyMessage failures in a
+		// *BlockValidationError; don't wrap it a second time here.
+		return nil, err
+	}
+
+	meth.commonTxs = append(meth.commonTxs, tx)
+	meth.receipts = append(meth.receipts, receipt)
+	return receipt, nil
+}
+
 func (p *StateProcessor) Commit(meth *MethSimulation, statedb *state.StateDB) (*state.StateDB, []*types.Receipt, []*types.Log, uint64, error) {
 	block, header, receipts, systemTxs, usedGas, commonTxs, bloomProcessors := meth.block, meth.header, meth.receipts, meth.systemTxs, meth.usedGas, meth.commonTxs, meth.bloomProcessors
 
@@ -203,6 +245,9 @@ func (p *StateProcessor) Commit(meth *MethSimulation, statedb *state.StateDB) (*
 // returns the amount of gas that was used in the process. If any of the
 // transactions failed to execute due to insufficient gas it will return an error.
 func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (*state.StateDB, types.Receipts, []*types.Log, uint64, error) {
+	if p.parallel {
+		return p.processParallel(block, statedb, cfg)
+	}
 	var (
 		usedGas     = new(uint64)
 		header      = block.Header()
@@ -245,7 +290,7 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		if isPoSA {
 			if isSystemTx, err := posa.IsSystemTransaction(tx, block.Header()); err != nil {
 				bloomProcessors.Close()
-				return statedb, nil, nil, 0, err
+				return statedb, nil, nil, 0, newBlockValidationError(err, blockNumber, blockHash, i, tx.Hash())
 			} else if isSystemTx {
 				systemTxs = append(systemTxs, tx)
 				continue
@@ -255,14 +300,16 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		msg, err := TransactionToMessage(tx, signer, header.BaseFee)
 		if err != nil {
 			bloomProcessors.Close()
-			return statedb, nil, nil, 0, err
+			return statedb, nil, nil, 0, newBlockValidationError(err, blockNumber, blockHash, i, tx.Hash())
 		}
 		statedb.SetTxContext(tx.Hash(), i)
 
 		receipt, err := applyTransaction(msg, p.config, gp, statedb, blockNumber, blockHash, tx, usedGas, vmenv, bloomProcessors)
 		if err != nil {
+			// applyTransaction already wraps ApplyMessage failures in a
+			// *BlockValidationError; don't wrap it a second time here.
 			bloomProcessors.Close()
-			return statedb, nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+			return statedb, nil, nil, 0, err
 		}
 		commonTxs = append(commonTxs, tx)
 		receipts = append(receipts, receipt)
@@ -288,6 +335,15 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 }
 
 func applyTransaction(msg *Message, config *params.ChainConfig, gp *GasPool, statedb *state.StateDB, blockNumber *big.Int, blockHash common.Hash, tx *types.Transaction, usedGas *uint64, evm *vm.EVM, receiptProcessors ...ReceiptProcessor) (*types.Receipt, error) {
+	receipt, _, err := applyTransactionResult(msg, config, gp, statedb, blockNumber, blockHash, tx, usedGas, evm, receiptProcessors...)
+	return receipt, err
+}
+
+// applyTransactionResult is the shared core of applyTransaction. It also
+// returns the raw *ExecutionResult so that callers that need the revert
+// reason bytes (e.g. the bundle simulator) don't have to re-execute the
+// message to get at them.
+func applyTransactionResult(msg *Message, config *params.ChainConfig, gp *GasPool, statedb *state.StateDB, blockNumber *big.Int, blockHash common.Hash, tx *types.Transaction, usedGas *uint64, evm *vm.EVM, receiptProcessors ...ReceiptProcessor) (*types.Receipt, *ExecutionResult, error) {
 	// Create a new context to be used in the EVM environment.
 	txContext := NewEVMTxContext(msg)
 	evm.Reset(txContext, statedb)
@@ -295,7 +351,7 @@ func applyTransaction(msg *Message, config *params.ChainConfig, gp *GasPool, sta
 	// Apply the transaction to the current state (included in the env).
 	result, err := ApplyMessage(evm, msg, gp)
 	if err != nil {
-		return nil, err
+		return nil, nil, newBlockValidationError(err, blockNumber, blockHash, int(statedb.TxIndex()), tx.Hash())
 	}
 
 	// Update the state with pending changes.
@@ -331,7 +387,7 @@ func applyTransaction(msg *Message, config *params.ChainConfig, gp *GasPool, sta
 	for _, receiptProcessor := range receiptProcessors {
 		receiptProcessor.Apply(receipt)
 	}
-	return receipt, err
+	return receipt, result, nil
 }
 
 // ApplyTransaction attempts to apply a transaction to the given state database
@@ -341,7 +397,7 @@ func applyTransaction(msg *Message, config *params.ChainConfig, gp *GasPool, sta
 func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config, receiptProcessors ...ReceiptProcessor) (*types.Receipt, error) {
 	msg, err := TransactionToMessage(tx, types.MakeSigner(config, header.Number, header.Time), header.BaseFee)
 	if err != nil {
-		return nil, err
+		return nil, newBlockValidationError(err, header.Number, header.Hash(), int(statedb.TxIndex()), tx.Hash())
 	}
 	// Create a new context to be used in the EVM environment
 	blockContext := NewEVMBlockContext(header, bc, author)